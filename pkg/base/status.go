@@ -0,0 +1,37 @@
+// Package base 定义下载器各组件共用的基础类型，避免 downloader 包与
+// 上层调用方之间产生循环依赖。
+package base
+
+// Status 表示一个下载任务所处的生命周期阶段。
+type Status int
+
+const (
+	// Ready 任务已创建但尚未开始下载
+	Ready Status = iota
+	// Start 任务正在下载中
+	Start
+	// Pause 任务已暂停，可通过 Resume 继续
+	Pause
+	// Error 任务因错误而终止
+	Error
+	// Done 任务已完成
+	Done
+)
+
+// String 实现 fmt.Stringer，便于日志输出。
+func (s Status) String() string {
+	switch s {
+	case Ready:
+		return "Ready"
+	case Start:
+		return "Start"
+	case Pause:
+		return "Pause"
+	case Error:
+		return "Error"
+	case Done:
+		return "Done"
+	default:
+		return "Unknown"
+	}
+}
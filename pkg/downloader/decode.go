@@ -0,0 +1,36 @@
+package downloader
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// contentDecoder 根据响应的 Content-Encoding 头返回一个透明解压后的 ReadCloser，
+// 缺失该头时原样返回 resp.Body，不支持的编码返回错误。由 downloadChunkToFile 与 DownloadFile 共用，
+// 避免压缩后的字节被直接当作文件内容写盘。
+func contentDecoder(resp *http.Response) (io.ReadCloser, error) {
+	switch resp.Header.Get("Content-Encoding") {
+	case "":
+		return resp.Body, nil
+	case "gzip":
+		return gzip.NewReader(resp.Body)
+	case "deflate":
+		return flate.NewReader(resp.Body), nil
+	case "br":
+		return io.NopCloser(brotli.NewReader(resp.Body)), nil
+	case "zstd":
+		zr, err := zstd.NewReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("不支持的 Content-Encoding：%s", resp.Header.Get("Content-Encoding"))
+	}
+}
@@ -0,0 +1,157 @@
+package downloader
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestContentDecoder(t *testing.T) {
+	want := []byte("hello weblink content decoder")
+
+	cases := []struct {
+		encoding string
+		encode   func([]byte) []byte
+	}{
+		{"", func(b []byte) []byte { return b }},
+		{"gzip", func(b []byte) []byte {
+			var buf bytes.Buffer
+			w := gzip.NewWriter(&buf)
+			_, _ = w.Write(b)
+			_ = w.Close()
+			return buf.Bytes()
+		}},
+		{"deflate", func(b []byte) []byte {
+			var buf bytes.Buffer
+			w, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+			_, _ = w.Write(b)
+			_ = w.Close()
+			return buf.Bytes()
+		}},
+		{"br", func(b []byte) []byte {
+			var buf bytes.Buffer
+			w := brotli.NewWriter(&buf)
+			_, _ = w.Write(b)
+			_ = w.Close()
+			return buf.Bytes()
+		}},
+		{"zstd", func(b []byte) []byte {
+			var buf bytes.Buffer
+			w, _ := zstd.NewWriter(&buf)
+			_, _ = w.Write(b)
+			_ = w.Close()
+			return buf.Bytes()
+		}},
+	}
+
+	for _, c := range cases {
+		name := c.encoding
+		if name == "" {
+			name = "identity"
+		}
+		t.Run(name, func(t *testing.T) {
+			resp := &http.Response{
+				Header: http.Header{},
+				Body:   io.NopCloser(bytes.NewReader(c.encode(want))),
+			}
+			if c.encoding != "" {
+				resp.Header.Set("Content-Encoding", c.encoding)
+			}
+
+			rc, err := contentDecoder(resp)
+			if err != nil {
+				t.Fatalf("contentDecoder: %v", err)
+			}
+			defer rc.Close()
+
+			got, err := io.ReadAll(rc)
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestContentDecoderUnsupportedEncoding(t *testing.T) {
+	resp := &http.Response{
+		Header: http.Header{"Content-Encoding": []string{"compress"}},
+		Body:   io.NopCloser(bytes.NewReader(nil)),
+	}
+	if _, err := contentDecoder(resp); err == nil {
+		t.Fatal("expected error for unsupported Content-Encoding")
+	}
+}
+
+// 存在 Content-Encoding 时，Content-Length 与 Range 偏移量指向的是压缩后的字节流，
+// 即便服务器同时声明 Accept-Ranges，也必须强制单线程整体下载，否则分片各自独立解压
+// 会得到错位、损坏的结果
+func TestFetchInfoForcesSingleStreamOnContentEncoding(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Content-Length", "10")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	d := New()
+	job, err := d.NewJob(srv.URL + "/file.bin")
+	if err != nil {
+		t.Fatalf("NewJob: %v", err)
+	}
+
+	if err := job.fetchInfo(); err != nil {
+		t.Fatalf("fetchInfo: %v", err)
+	}
+
+	if job.isSupportRange {
+		t.Fatal("expected isSupportRange=false when Content-Encoding is present")
+	}
+}
+
+// 有些服务器只在分片 GET 上返回 Content-Encoding（HEAD 没有），fetchInfo 无从得知，
+// 必须在每个分片的响应上再次检查，否则会按 Range 独立解压、得到错位损坏的结果
+func TestDownloadChunkToFileDetectsLateContentEncoding(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Content-Range", "bytes 0-9/20")
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write([]byte("0123456789"))
+	}))
+	defer srv.Close()
+
+	d := New()
+	job, err := d.NewJob(srv.URL + "/file.bin")
+	if err != nil {
+		t.Fatalf("NewJob: %v", err)
+	}
+	// 模拟 HEAD 阶段判定支持 Range 且没有 Content-Encoding
+	job.isSupportRange = true
+
+	target := filepath.Join(t.TempDir(), "out.bin")
+	if err := os.WriteFile(target, nil, 0o644); err != nil {
+		t.Fatalf("create target: %v", err)
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatalf("open target: %v", err)
+	}
+	defer f.Close()
+
+	var counter uint64
+	if err := job.downloadChunkToFile(f, Chunk{Start: 0, End: 9}, &counter); err == nil {
+		t.Fatal("expected error when a ranged chunk response unexpectedly carries Content-Encoding")
+	}
+}
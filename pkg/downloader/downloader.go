@@ -1,7 +1,6 @@
 package downloader
 
 import (
-	"compress/flate"
 	"context"
 	"errors"
 	"fmt"
@@ -15,13 +14,11 @@ import (
 	"strconv"
 	"strings"
 	"sync"
-	"syscall"
+	"sync/atomic"
 	"time"
-	"unsafe"
 
-	"github.com/jlaffaye/ftp"
-	"github.com/lxn/win"
 	"github.com/mzky/weblink/internal/log"
+	"github.com/mzky/weblink/pkg/base"
 )
 
 type Downloader struct {
@@ -29,6 +26,14 @@ type Downloader struct {
 	Option
 
 	afterCreateJobInterceptor AfterCreateJobInterceptor
+
+	fetcherBuilders  map[string]FetcherBuilder // 按 URL scheme 注册的协议实现
+	progressListener ProgressListener          // 对所有 Job 生效的进度回调
+
+	limiter *globalLimiter // 跨 Job 的全局限速与并发控制
+
+	resolverMu         sync.RWMutex
+	saveTargetResolver SaveTargetResolver // 保存位置确认方式，默认值因平台而异，详见 savedialog_*.go
 }
 
 type Job struct {
@@ -40,9 +45,20 @@ type Job struct {
 	FileName       string
 	FileSize       uint64
 	isSupportRange bool
-	isFtp          bool
 
-	_lck *sync.Mutex // 用于确保写入文件的顺序
+	etag         string // 远端资源的 ETag，用于断点续传时的条件请求
+	lastModified string // 远端资源的 Last-Modified，用于断点续传时的条件请求
+
+	status   base.Status // 任务当前状态，详见 Pause/Resume/Status
+	statusMu sync.Mutex
+	control  *controlFile  // 断点续传控制文件的内存映像，下载开始前由 loadResumeState 填充
+	pauseCh  chan struct{} // 关闭后通知所有分块 goroutine 暂停
+	stopOnce sync.Once     // 保证 pauseCh 只被关闭一次
+
+	fetcher Fetcher // 本次下载实际使用的协议实现，由 Url.Scheme 决定
+
+	progressListener ProgressListener // 仅对当前 Job 生效的进度回调
+	startedAt        time.Time        // 开始下载的时间，用于计算平均速度
 }
 
 type Option struct {
@@ -54,6 +70,10 @@ type Option struct {
 	Overwrite            bool           // 是否覆盖已存在的文件，默认false
 	Timeout              time.Duration  // 超时时间，默认10秒
 	Cookies              []*http.Cookie // Cookie
+	Integrity            string         // 下载完成后的完整性校验算法："" 不校验，"crc64"，"sha256"
+	ExpectedChecksum     string         // 期望的完整性摘要（十六进制），配合 Integrity 使用；为空则只计算摘要、不比对
+	MaxGlobalBytesPerSec int64          // 跨 Job 的全局限速，单位字节/秒，默认0表示不限速
+	MaxGlobalConnections int            // 跨 Job 的全局并发分片数上限，默认0表示不限制
 }
 
 type AfterCreateJobInterceptor func(job *Job)
@@ -67,6 +87,10 @@ func (opt Option) cloneOption() Option {
 		EnableSaveFileDialog: opt.EnableSaveFileDialog,
 		Overwrite:            opt.Overwrite,
 		Timeout:              opt.Timeout,
+		Integrity:            opt.Integrity,
+		ExpectedChecksum:     opt.ExpectedChecksum,
+		MaxGlobalBytesPerSec: opt.MaxGlobalBytesPerSec,
+		MaxGlobalConnections: opt.MaxGlobalConnections,
 	}
 }
 
@@ -97,12 +121,39 @@ func New(withOption ...func(*Option)) *Downloader {
 		Option:    opt,
 	}
 
+	downloader.limiter = newGlobalLimiter(opt.MaxGlobalBytesPerSec, opt.MaxGlobalConnections)
+	downloader.saveTargetResolver = defaultSaveTargetResolver()
+
 	// 空实现
 	downloader.afterCreateJobInterceptor = func(job *Job) {}
 
+	// 内置协议：HTTP(S) 与 FTP，第三方可通过 RegisterFetcher 注册更多协议
+	downloader.RegisterFetcher([]string{"http", "https"}, newHTTPFetcher)
+	downloader.RegisterFetcher([]string{"ftp"}, newFTPFetcher)
+
 	return downloader
 }
 
+// RegisterFetcher 为给定的一组 URL scheme 注册协议实现，后注册的会覆盖先前的。
+// 第三方包（如 WebDAV、网盘类驱动）可借此接入而无需修改 downloader 核心代码。
+func (d *Downloader) RegisterFetcher(schemes []string, builder FetcherBuilder) {
+	if d.fetcherBuilders == nil {
+		d.fetcherBuilders = make(map[string]FetcherBuilder)
+	}
+	for _, scheme := range schemes {
+		d.fetcherBuilders[scheme] = builder
+	}
+}
+
+// fetcherFor 根据 Job 的 URL scheme 查找对应的协议实现
+func (d *Downloader) fetcherFor(job *Job) (Fetcher, error) {
+	builder, ok := d.fetcherBuilders[job.Url.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("不支持的协议：%s", job.Url.Scheme)
+	}
+	return builder(job), nil
+}
+
 func (d *Downloader) Download(url string, withOption ...func(*Option)) error {
 	job, err := d.NewJob(url, withOption...)
 	if err != nil {
@@ -142,7 +193,9 @@ func (d *Downloader) NewJob(url string, withOption ...func(*Option)) (*Job, erro
 		FileName:       "",
 		FileSize:       0,
 		isSupportRange: false,
-		isFtp:          Url.Scheme == "ftp",
+
+		status:  base.Ready,
+		pauseCh: make(chan struct{}),
 	}
 
 	d.afterCreateJobInterceptor(job)
@@ -236,47 +289,46 @@ func (job *Job) Download() error {
 	case <-time.After(job.Timeout):
 		return errors.New("下载超时")
 	default:
-		if job.isFtp {
-			return job.downloadFtp()
-		}
-
-		return job.downloadHttp()
-	}
-}
+		job.setStatus(base.Start)
+		job.startedAt = time.Now()
 
-func (job *Job) downloadFtp() error {
+		fetcher, err := job.downloader.fetcherFor(job)
+		if err != nil {
+			job.setStatus(base.Error)
+			return err
+		}
+		job.fetcher = fetcher
 
-	c, err := ftp.Dial(job.Url.Host+job.Url.Port(), ftp.DialWithTimeout(5*time.Second))
-	if err != nil {
-		job.logErr("打开 FTP 出错：" + err.Error())
-		return errors.New("链接 FTP 服务器出错")
-	}
+		res, err := fetcher.Resolve(&Request{Url: job.Url.String(), Job: job})
+		if err != nil {
+			job.logErr("获取文件信息出错：" + err.Error())
+			job.setStatus(base.Error)
+			return err
+		}
 
-	username := job.Url.User.Username()
-	password, _ := job.Url.User.Password()
+		if err := fetcher.Fetch(res, &job.Option); err != nil {
+			job.logErr(err.Error())
+			job.setStatus(base.Error)
+			return err
+		}
 
-	if username == "" {
-		username = "anonymous"
+		// 下载过程中被 Pause 会让 fetcher.Fetch 提前返回 nil，此时任务状态已是
+		// Pause，不应被这里覆盖成 Done
+		if job.Status() != base.Pause {
+			job.logDebug("下载完成：%s", job.TargetFile())
+			job.setStatus(base.Done)
+		}
+		return nil
 	}
+}
 
-	err = c.Login(username, password)
-	defer c.Quit()
-	if err != nil {
-		job.logErr("登录 FTP 出错：" + err.Error())
-		return errors.New("登录 FTP 出错")
-	}
-
-	if job.EnableSaveFileDialog {
-		if path, ok := openSaveFileDialog(job.TargetFile()); ok {
-			dir, file := filepath.Split(path)
-			job.FileName = file
-			job.Dir = dir
-		} else {
-			job.logDebug("用户取消保存。")
-			return nil
-		}
-	} else {
-		job.FileName = filepath.Base(job.Url.Path)
+// downloadHttpFetch 在 fetchInfo 探测完远端信息之后执行实际下载：
+// 弹出保存对话框（若启用）、校验文件名，再交给 multiThreadDownload。
+// 由 httpFetcher.Fetch 调用。
+func (job *Job) downloadHttpFetch() error {
+	if _, ok := job.resolveTarget(); !ok {
+		job.logDebug("用户取消保存。")
+		return nil
 	}
 
 	if job.FileName == "" || !strings.Contains(job.FileName, ".") {
@@ -284,112 +336,113 @@ func (job *Job) downloadFtp() error {
 		return errors.New("文件名不正确。")
 	}
 
-	job.logDebug("创建任务 %s", job.Url.String())
-
-	r, err := c.Retr(job.Url.Path)
-	if err != nil {
-		return err
-	}
-	defer r.Close()
+	return job.multiThreadDownload()
+}
 
-	// 打开文件准备写入
-	file, err := job.createTargetFile()
-	if err != nil {
-		return err
-	}
-	defer file.Close()
+func (job *Job) multiThreadDownload() error {
 
-	buf, err := io.ReadAll(r)
-	if err != nil {
-		return err
+	if job.control == nil {
+		job.control = job.loadResumeState()
 	}
 
-	_, err = file.Write(buf)
-
-	return err
-}
-
-func (job *Job) downloadHttp() error {
-	if err := job.fetchInfo(); err != nil {
-		job.logErr("获取文件信息出错：" + err.Error())
-		return err
+	missing := job.control.missingRanges()
+	if len(missing) == 0 && job.control.TotalSize > 0 {
+		job.logDebug("控制文件显示分块已全部完成，跳过下载")
+		return job.finishDownload()
 	}
 
-	job.logDebug("创建任务 %s", job.Url)
-	if job.EnableSaveFileDialog {
-		if path, ok := openSaveFileDialog(job.TargetFile()); ok {
-			dir, file := filepath.Split(path)
-			job.FileName = file
-			job.Dir = dir
-		} else {
-			job.logDebug("用户取消保存。")
-			return nil
+	// 全新下载时按既有的覆盖/重命名策略先确定目标文件名；续传时沿用既有文件，不再重命名
+	if len(job.control.Completed) == 0 {
+		file, err := job.createTargetFile()
+		if err != nil {
+			return err
 		}
+		file.Close()
 	}
 
-	if job.FileName == "" || !strings.Contains(job.FileName, ".") {
-		job.logDebug("文件名不正确: %s", job.FileName)
-		return errors.New("文件名不正确。")
-	}
-
-	if err := job.multiThreadDownload(); err != nil {
-		job.logErr(err.Error())
-		return err
+	var chunks []Chunk
+	if job.isSupportRange {
+		chunks = newSplitter(job.MinChunkSize).Split(missing)
+	} else {
+		// 服务器不支持 Range（或存在 Content-Encoding）时无法按偏移寻址分片，
+		// 整个缺失区间只能作为一个不带 Range 头的分片整体下载
+		end := uint64(0)
+		if job.control.TotalSize > 0 {
+			end = job.control.TotalSize - 1
+		}
+		chunks = []Chunk{{Start: 0, End: end}}
 	}
 
-	job.logDebug("下载完成：%s", job.TargetFile())
-	return nil
-}
-
-func (job *Job) multiThreadDownload() error {
-
 	theads := job.AvaiableTreads()
-	job.logDebug("文件将以多线程进行下载，线程：%d", theads)
+	if theads > len(chunks) {
+		theads = len(chunks)
+	}
+	job.logDebug("文件将以多线程进行下载，线程：%d，分片：%d", theads, len(chunks))
 
-	// 打开文件准备写入
-	file, err := job.createTargetFile()
+	// 每个分片直接写入目标文件中自己的偏移处（见 downloadChunkToFile），不再先写入
+	// 独立的 part 文件再统一合并：旧实现在合并前被 Pause 时，已下载的分片只停留在
+	// part 文件里、从未写进目标文件，控制文件却已把它们标记为完成，导致续传/完成后
+	// 目标文件存在从未真正落盘的区间
+	file, err := os.OpenFile(job.TargetFile(), os.O_WRONLY, 0o644)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
+	queue := make(chan Chunk, len(chunks))
+	for _, c := range chunks {
+		queue <- c
+	}
+	close(queue)
+
 	var wg sync.WaitGroup
 	var ctx, cancel = context.WithCancel(context.Background())
 	var errs []error
 	var errLock sync.Mutex
+	var controlLock sync.Mutex
 
 	defer cancel() // 取消所有goroutine
 
-	// 计算每个线程的分块大小
-	chunkSize := uint64(math.Ceil(float64(job.FileSize) / float64(theads)))
+	// 每个分块一个原子计数器，供进度汇报使用
+	counters := make([]uint64, len(chunks))
 
-	for i := 0; i < theads; i++ {
-		start := uint64(i) * chunkSize
-		end := start + chunkSize - 1
-
-		// 如果是最后一个部分，加上余数
-		if i == theads-1 {
-			end = job.FileSize - 1
-		}
+	progressDone := make(chan struct{})
+	go job.reportProgress(chunkRanges(chunks), counters, progressDone)
+	defer close(progressDone)
 
+	for w := 0; w < theads; w++ {
 		wg.Add(1)
 
-		go func(index int) {
+		go func() {
 			defer wg.Done()
 
-			retry := 0
-			for {
-				select {
-				case <-ctx.Done():
-					// 如果收到取消信号，直接返回
-					return
-				default:
-					// 尝试下载分块
-					err := job.downloadChunk(file, start, end)
+			for chunk := range queue {
+				retry := 0
+				for {
+					select {
+					case <-ctx.Done():
+						// 如果收到取消信号，直接返回
+						return
+					case <-job.pauseCh:
+						// 任务被暂停，已完成的分片已写入控制文件，直接返回
+						return
+					default:
+					}
+
+					// 尝试下载分片，每个分片写入目标文件中互不重叠的偏移，无需加锁
+					atomic.StoreUint64(&counters[chunk.Index], 0)
+					err := job.downloadChunkToFile(file, chunk, &counters[chunk.Index])
 
 					if err == nil {
-						job.logDebug("切片 %d 下载完成", index+1)
-						return
+						controlLock.Lock()
+						job.control.markCompleted(chunk.Start, chunk.End)
+						if saveErr := job.control.save(job.TargetFile()); saveErr != nil {
+							job.logErr("保存续传控制文件出错：" + saveErr.Error())
+						}
+						controlLock.Unlock()
+
+						job.logDebug("切片 %d 下载完成", chunk.Index+1)
+						break
 					}
 
 					// 如果重试超过3次，记录错误并触发取消操作
@@ -404,20 +457,45 @@ func (job *Job) multiThreadDownload() error {
 					retry++
 				}
 			}
-		}(i)
+		}()
 	}
 
 	wg.Wait() // 等待所有goroutine完成
 
+	if job.Status() == base.Pause {
+		return nil
+	}
+
 	if len(errs) > 0 {
 		return errs[0] // 返回第一个遇到的错误
 	}
 
-	return nil
+	job.emitFinalProgress(chunkRanges(chunks), counters)
+
+	return job.finishDownload()
+}
+
+// offsetWriter 把顺序写入转发为文件在固定起始偏移之后的连续 WriteAt。多个 worker
+// 可以并发写同一个 *os.File 而不会像共享 Seek 游标那样互相踩踏偏移。
+type offsetWriter struct {
+	file   *os.File
+	offset int64
 }
 
-// downloadChunk 下载文件的单个分块
-func (job *Job) downloadChunk(file *os.File, start, end uint64) error {
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.file.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	return n, err
+}
+
+// downloadChunkToFile 下载单个分片，直接写入目标文件中 chunk.Start 起始的偏移。
+// 多个 worker 共享同一个 *os.File 并发写入，但各自的偏移区间互不重叠，因此不需要加锁；
+// 写入随下载即时落盘，暂停也不会丢失已完成分片的数据。
+func (job *Job) downloadChunkToFile(file *os.File, chunk Chunk, counter *uint64) error {
+	// 占用一个全局并发名额，受 Downloader.SetMaxConnections 控制
+	release := job.downloader.limiter.acquire()
+	defer release()
+
 	req, err := http.NewRequest("GET", job.Url.String(), nil)
 	if err != nil {
 		return err
@@ -426,30 +504,44 @@ func (job *Job) downloadChunk(file *os.File, start, end uint64) error {
 	for _, cookie := range job.Option.Cookies {
 		req.AddCookie(cookie)
 	}
-	// 设置Range头实现断点续传
-	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+
+	// 不支持 Range 的资源（见 fetchInfo）不发送 Range 头，按普通 GET 整体下载
+	wantStatus := http.StatusOK
+	if job.isSupportRange {
+		// 设置Range头实现断点续传
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", chunk.Start, chunk.End))
+		wantStatus = http.StatusPartialContent
+	}
+
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	// 检查服务器是否支持Range请求
-	if resp.StatusCode != http.StatusPartialContent {
-		return errors.New("server doesn't support Range requests")
+	if resp.StatusCode != wantStatus {
+		if job.isSupportRange {
+			return errors.New("server doesn't support Range requests")
+		}
+		return fmt.Errorf("服务器返回非预期状态码：%d", resp.StatusCode)
 	}
 
-	// 锁定互斥锁以安全地写入文件
-	job._lck.Lock()
-	defer job._lck.Unlock()
+	// fetchInfo 只在 HEAD 响应里探测过 Content-Encoding；如果服务器只对分片 GET
+	// 返回了压缩编码（HEAD 上没有），按 Range 分别解压会得到错位、损坏的结果，
+	// 此处按分片再次检查一遍，宁可下载失败重试也不能让其静默通过
+	if job.isSupportRange && resp.Header.Get("Content-Encoding") != "" {
+		return fmt.Errorf("分片 %d 的响应带有 Content-Encoding：%s，无法按 Range 安全下载", chunk.Index, resp.Header.Get("Content-Encoding"))
+	}
 
-	// 写入文件的当前位置
-	if _, err = file.Seek(int64(start), io.SeekStart); err != nil {
+	body, err := contentDecoder(resp)
+	if err != nil {
 		return err
 	}
+	defer body.Close()
 
-	// 将HTTP响应的Body内容写入到文件中
-	_, err = io.Copy(file, resp.Body)
+	// 将解压后的内容写入目标文件中 chunk.Start 起始的偏移，同时统计已下载字节数并受全局限速约束
+	reader := job.downloader.limiter.throttle(&countingReader{r: body, counter: counter})
+	_, err = io.Copy(&offsetWriter{file: file, offset: int64(chunk.Start)}, reader)
 	return err
 }
 
@@ -464,6 +556,18 @@ func (job *Job) fetchInfo() error {
 		req.AddCookie(cookie)
 	}
 
+	// 若目标文件旁已存在续传控制文件，附带 If-Range 条件头让服务器确认资源未变更。
+	// 此时文件名尚未最终确定（保存对话框/Content-Disposition 都在本次请求之后才解析），
+	// 先用 URL 推断出的文件名做探测性查找。
+	provisionalTarget := filepath.Join(job.Dir, job.FileNamePrefix+getFileNameByUrl(job.Url.Path))
+	if cf := loadControlFile(provisionalTarget); cf != nil && cf.URL == job.Url.String() {
+		if cf.ETag != "" {
+			req.Header.Set("If-Range", cf.ETag)
+		} else if cf.LastModified != "" {
+			req.Header.Set("If-Range", cf.LastModified)
+		}
+	}
+
 	r, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
@@ -488,6 +592,15 @@ func (job *Job) fetchInfo() error {
 		job.isSupportRange = true
 	}
 
+	// 存在 Content-Encoding 时，Content-Length 与 Range 偏移量指向的都是压缩后的
+	// 字节流，分片下载会各自独立解压从而得到错位、损坏的结果，因此强制单线程整体下载
+	if r.Header.Get("Content-Encoding") != "" {
+		job.isSupportRange = false
+	}
+
+	job.etag = r.Header.Get("ETag")
+	job.lastModified = r.Header.Get("Last-Modified")
+
 	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Content-Length
 	// 获取文件总大小 #有些连接无法获取文件大小
 	contentLength, err := strconv.ParseUint(r.Header.Get("Content-Length"), 10, 64)
@@ -523,22 +636,6 @@ func getFileNameByUrl(downloadUrl string) string {
 	return filepath.Base(parsedUrl.Path)
 }
 
-func openSaveFileDialog(fileName string) (filePath string, ok bool) {
-	var ofn win.OPENFILENAME
-	buf := make([]uint16, syscall.MAX_PATH)
-	ofn.LStructSize = uint32(unsafe.Sizeof(ofn))
-	ofn.NMaxFile = uint32(len(buf))
-	ofn.LpstrFile, _ = syscall.UTF16PtrFromString(fileName)
-	ofn.Flags = win.OFN_OVERWRITEPROMPT | win.OFN_EXPLORER | win.OFN_FILEMUSTEXIST | win.OFN_PATHMUSTEXIST | win.OFN_LONGNAMES
-	ofn.LpstrTitle, _ = syscall.UTF16PtrFromString("保存文件")
-	ofn.LpstrFilter, _ = syscall.UTF16PtrFromString("All Files (*.*)")
-	ok = win.GetSaveFileName(&ofn)
-	if ok {
-		filePath = syscall.UTF16ToString((*[1 << 16]uint16)(unsafe.Pointer(ofn.LpstrFile))[:])
-	}
-	return
-}
-
 func (job *Job) logDebug(tpl string, vars ...interface{}) {
 	log.Debug(fmt.Sprintf("[下载任务 %d ]: ", job.id)+tpl, vars...)
 }
@@ -580,7 +677,7 @@ func (job *Job) DownloadFile() error {
 	job.FileName = getFileName(r)
 
 	// 创建本地文件
-	path, ok := openSaveFileDialog(job.TargetFile())
+	path, ok := job.resolveTarget()
 	if !ok {
 		return fmt.Errorf("请选择保存文件位置")
 	}
@@ -590,16 +687,12 @@ func (job *Job) DownloadFile() error {
 	}
 	defer localFile.Close()
 
-	var reader io.Reader
-	// 检查Content-Encoding是否为deflate
-	contentEncoding := r.Header.Get("Content-Encoding")
-	if contentEncoding == "deflate" {
-		// 如果是deflate编码，解压缩数据
-		reader = flate.NewReader(r.Body)
-	} else {
-		// 如果不是deflate编码，直接将响应体内容写入文件
-		reader = r.Body
+	reader, err := contentDecoder(r)
+	if err != nil {
+		return err
 	}
+	defer reader.Close()
+
 	if _, e := io.Copy(localFile, reader); e != nil {
 		return e
 	}
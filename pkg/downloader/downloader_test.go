@@ -0,0 +1,77 @@
+package downloader
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// 之前的实现在完成下载后才把各分片的 part 文件合并进目标文件；若本次运行在合并前
+// 被 Pause，已下载的分片只停留在 part 文件里、从未写进目标文件，但控制文件已把它们
+// 标记为完成。续传时新一轮分片会重用从 0 开始的 part 文件名，旧分片的数据因此永久
+// 丢失，最终文件出现从未真正落盘的区间。现在分片直接写入目标文件对应的偏移，
+// 这个测试验证：续传时已经记录为完成的区间即便只存在于目标文件里（没有任何
+// part 文件），最终结果仍然完整、正确。
+func TestMultiThreadDownloadResumePreservesCompletedRanges(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 4096) // 40960 字节
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	d := New(func(o *Option) {
+		o.Dir = dir
+		o.MinChunkSize = 4096
+		o.MaxThreads = 4
+	})
+
+	job, err := d.NewJob(srv.URL + "/file.bin")
+	if err != nil {
+		t.Fatalf("NewJob: %v", err)
+	}
+	job.FileName = "file.bin"
+
+	if err := job.fetchInfo(); err != nil {
+		t.Fatalf("fetchInfo: %v", err)
+	}
+	if !job.isSupportRange {
+		t.Fatal("expected test server to support Range requests")
+	}
+
+	target := job.TargetFile()
+	prefixEnd := uint64(len(data)/2 - 1)
+
+	// 模拟上一轮已经把前半部分真正落盘到目标文件，控制文件也记录其已完成
+	if err := os.WriteFile(target, data[:prefixEnd+1], 0o644); err != nil {
+		t.Fatalf("seed target file: %v", err)
+	}
+	cf := &controlFile{
+		URL:       job.Url.String(),
+		TotalSize: job.FileSize,
+		Completed: []byteRange{{Start: 0, End: prefixEnd}},
+	}
+	if err := cf.save(target); err != nil {
+		t.Fatalf("seed control file: %v", err)
+	}
+
+	if err := job.multiThreadDownload(); err != nil {
+		t.Fatalf("multiThreadDownload: %v", err)
+	}
+
+	got, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatalf("read target: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("resumed download produced %d bytes, want %d matching the original content", len(got), len(data))
+	}
+
+	if _, err := os.Stat(controlFilePath(target)); !os.IsNotExist(err) {
+		t.Fatalf("expected control file to be removed after completion, stat err=%v", err)
+	}
+}
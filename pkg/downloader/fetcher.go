@@ -0,0 +1,35 @@
+package downloader
+
+// Request 描述一次下载请求的最小上下文，交由 Fetcher.Resolve 探测远端资源信息
+type Request struct {
+	Url string
+	Job *Job
+}
+
+// Resource 是 Resolve 阶段探测出的远端资源元信息
+type Resource struct {
+	FileName       string
+	FileSize       uint64
+	IsSupportRange bool
+	ETag           string
+	LastModified   string
+}
+
+// Fetcher 是单个协议的下载实现。Downloader 按 Url.Scheme 从已注册的
+// FetcherBuilder 中选择对应实现，替代原先写死的 HTTP/FTP 分支，
+// 使 WebDAV、磁力链接、云盘 API 等协议可以在不修改 downloader 核心的情况下接入。
+type Fetcher interface {
+	// Resolve 探测远端资源的元信息（大小、是否支持断点续传等），不产生实际下载流量
+	Resolve(req *Request) (*Resource, error)
+	// Fetch 依据 Resolve 得到的 Resource 执行真正的下载
+	Fetch(res *Resource, opts *Option) error
+	// Pause 暂停当前下载
+	Pause()
+	// Resume 从暂停处继续下载
+	Resume() error
+	// Cancel 取消下载
+	Cancel()
+}
+
+// FetcherBuilder 为一个 Job 构造对应的 Fetcher 实例
+type FetcherBuilder func(job *Job) Fetcher
@@ -0,0 +1,42 @@
+package downloader
+
+// httpFetcher 是内置的 HTTP/HTTPS 协议实现，迁移自原先写死在 Job 上的
+// fetchInfo + 多线程下载逻辑，保持与重构前完全一致的行为。
+type httpFetcher struct {
+	job *Job
+}
+
+func newHTTPFetcher(job *Job) Fetcher {
+	return &httpFetcher{job: job}
+}
+
+func (f *httpFetcher) Resolve(req *Request) (*Resource, error) {
+	if err := f.job.fetchInfo(); err != nil {
+		return nil, err
+	}
+	f.job.logDebug("创建任务 %s", f.job.Url)
+
+	return &Resource{
+		FileName:       f.job.FileName,
+		FileSize:       f.job.FileSize,
+		IsSupportRange: f.job.isSupportRange,
+		ETag:           f.job.etag,
+		LastModified:   f.job.lastModified,
+	}, nil
+}
+
+func (f *httpFetcher) Fetch(res *Resource, opts *Option) error {
+	return f.job.downloadHttpFetch()
+}
+
+func (f *httpFetcher) Pause() {
+	// 实际的暂停信号由 Job.Pause 统一通过 pauseCh 下发，此处无需额外动作
+}
+
+func (f *httpFetcher) Resume() error {
+	return f.job.multiThreadDownload()
+}
+
+func (f *httpFetcher) Cancel() {
+	// 控制文件的清理由 Job.Cancel 统一处理
+}
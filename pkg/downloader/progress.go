@@ -0,0 +1,156 @@
+package downloader
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/mzky/weblink/pkg/base"
+)
+
+// defaultProgressInterval 是进度事件的默认上报间隔
+const defaultProgressInterval = 500 * time.Millisecond
+
+// ProgressListener 接收下载进度事件
+type ProgressListener func(ev ProgressEvent)
+
+// ChunkProgress 描述单个分块（线程）的下载进度
+type ChunkProgress struct {
+	Index      int
+	Start      uint64
+	End        uint64
+	Downloaded uint64
+}
+
+// ProgressEvent 描述某一时刻任务的整体下载进度。全部分块完成后由 emitFinalProgress
+// 派发的最后一个事件会额外携带本次下载的平均速度与总耗时（Elapsed）；该事件在完整性
+// 校验之前发出，其 Status 仍为 base.Start，而非 base.Done。
+type ProgressEvent struct {
+	JobID      uint64
+	Downloaded uint64
+	Total      uint64
+	SpeedBps   float64
+	ETA        time.Duration
+	Chunks     []ChunkProgress
+	Status     base.Status
+	Elapsed    time.Duration
+
+	// GlobalConnInUse/GlobalConnCap 反映 Downloader 级别的全局并发占用情况，
+	// GlobalConnCap 为 0 表示未设置 MaxGlobalConnections 限制
+	GlobalConnInUse int
+	GlobalConnCap   int
+}
+
+// OnProgress 注册 Downloader 级别的进度回调，对其创建的所有 Job 生效
+func (d *Downloader) OnProgress(listener ProgressListener) {
+	d.progressListener = listener
+}
+
+// OnProgress 注册仅对当前 Job 生效的进度回调
+func (job *Job) OnProgress(listener ProgressListener) {
+	job.progressListener = listener
+}
+
+// emitProgress 把事件派发给 Job 与 Downloader 两级监听器
+func (job *Job) emitProgress(ev ProgressEvent) {
+	ev.JobID = job.id
+	ev.Status = job.Status()
+
+	if job.downloader != nil && job.downloader.limiter != nil {
+		ev.GlobalConnInUse, ev.GlobalConnCap = job.downloader.limiter.inUse()
+	}
+
+	if job.progressListener != nil {
+		job.progressListener(ev)
+	}
+	if job.downloader != nil && job.downloader.progressListener != nil {
+		job.downloader.progressListener(ev)
+	}
+}
+
+// reportProgress 按 defaultProgressInterval 定时汇总各分块的下载字节数并派发事件，
+// done 被关闭后退出。由 multiThreadDownload 在后台启动。
+func (job *Job) reportProgress(ranges []byteRange, counters []uint64, done <-chan struct{}) {
+	if job.progressListener == nil && (job.downloader == nil || job.downloader.progressListener == nil) {
+		return
+	}
+
+	ticker := time.NewTicker(defaultProgressInterval)
+	defer ticker.Stop()
+
+	var lastDownloaded uint64
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			downloaded, chunks := job.snapshotChunks(ranges, counters)
+
+			speed := float64(downloaded-lastDownloaded) / defaultProgressInterval.Seconds()
+			lastDownloaded = downloaded
+
+			var eta time.Duration
+			if speed > 0 && job.FileSize > downloaded {
+				eta = time.Duration(float64(job.FileSize-downloaded)/speed) * time.Second
+			}
+
+			job.emitProgress(ProgressEvent{
+				Downloaded: downloaded,
+				Total:      job.FileSize,
+				SpeedBps:   speed,
+				ETA:        eta,
+				Chunks:     chunks,
+			})
+		}
+	}
+}
+
+// snapshotChunks 读取各分块当前的累计下载字节数
+func (job *Job) snapshotChunks(ranges []byteRange, counters []uint64) (uint64, []ChunkProgress) {
+	var downloaded uint64
+	chunks := make([]ChunkProgress, len(ranges))
+
+	for i, r := range ranges {
+		n := atomic.LoadUint64(&counters[i])
+		downloaded += n
+		chunks[i] = ChunkProgress{Index: i, Start: r.Start, End: r.End, Downloaded: n}
+	}
+
+	return downloaded, chunks
+}
+
+// emitFinalProgress 在全部分块下载完成后派发一个携带平均速度与总耗时的收尾事件
+func (job *Job) emitFinalProgress(ranges []byteRange, counters []uint64) {
+	downloaded, chunks := job.snapshotChunks(ranges, counters)
+
+	elapsed := time.Since(job.startedAt)
+
+	var avgSpeed float64
+	if elapsed > 0 {
+		avgSpeed = float64(downloaded) / elapsed.Seconds()
+	}
+
+	job.emitProgress(ProgressEvent{
+		Downloaded: downloaded,
+		Total:      job.FileSize,
+		SpeedBps:   avgSpeed,
+		Chunks:     chunks,
+		Elapsed:    elapsed,
+	})
+}
+
+// countingReader 包装 io.Reader，每次 Read 都会把读取到的字节数累加到 counter，
+// 用于在不改变调用方 io.Copy 用法的前提下统计下载进度。
+type countingReader struct {
+	r       io.Reader
+	counter *uint64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	if n > 0 {
+		atomic.AddUint64(cr.counter, uint64(n))
+	}
+	return n, err
+}
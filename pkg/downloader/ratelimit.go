@@ -0,0 +1,120 @@
+package downloader
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// globalLimiter 管理跨 Job 的全局带宽限速与全局并发分片数限制，
+// 由 Downloader 持有一个实例，所有 Job 共享。
+type globalLimiter struct {
+	mu          sync.RWMutex
+	rateLimiter *rate.Limiter
+	sem         chan struct{}
+}
+
+func newGlobalLimiter(bytesPerSec int64, maxConnections int) *globalLimiter {
+	gl := &globalLimiter{}
+	gl.setRate(bytesPerSec)
+	gl.setMaxConnections(maxConnections)
+	return gl
+}
+
+// setRate 调整全局限速，bytesPerSec <= 0 表示不限速
+func (gl *globalLimiter) setRate(bytesPerSec int64) {
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+
+	if bytesPerSec <= 0 {
+		gl.rateLimiter = nil
+		return
+	}
+	// burst 取 1 秒的配额，允许短时突发，长期平均速率仍受限；
+	// 但不能低于 io.Copy 单次读取的块大小（copyBufSize），否则限速低于该值时
+	// WaitN 会直接报 "exceeds limiter's burst" 而不是排队等待，导致分片下载失败
+	burst := int(bytesPerSec)
+	if burst < copyBufSize {
+		burst = copyBufSize
+	}
+	gl.rateLimiter = rate.NewLimiter(rate.Limit(bytesPerSec), burst)
+}
+
+// setMaxConnections 调整全局并发分片数上限，n <= 0 表示不限制
+func (gl *globalLimiter) setMaxConnections(n int) {
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+
+	if n <= 0 {
+		gl.sem = nil
+		return
+	}
+	gl.sem = make(chan struct{}, n)
+}
+
+// acquire 在分片开始下载前占用一个全局并发名额，release 必须在分片结束后调用
+func (gl *globalLimiter) acquire() (release func()) {
+	gl.mu.RLock()
+	sem := gl.sem
+	gl.mu.RUnlock()
+
+	if sem == nil {
+		return func() {}
+	}
+
+	sem <- struct{}{}
+	return func() { <-sem }
+}
+
+// inUse 返回当前已占用的全局连接数与总容量（容量为 0 表示未启用限制），供进度 API 展示利用率
+func (gl *globalLimiter) inUse() (used, capacity int) {
+	gl.mu.RLock()
+	defer gl.mu.RUnlock()
+
+	if gl.sem == nil {
+		return 0, 0
+	}
+	return len(gl.sem), cap(gl.sem)
+}
+
+// throttle 用全局限速包装 r，未设置限速时原样返回
+func (gl *globalLimiter) throttle(r io.Reader) io.Reader {
+	gl.mu.RLock()
+	limiter := gl.rateLimiter
+	gl.mu.RUnlock()
+
+	if limiter == nil {
+		return r
+	}
+	return &rateLimitedReader{r: r, limiter: limiter}
+}
+
+// rateLimitedReader 包装 io.Reader，使每次 Read 读取到的字节都受全局令牌桶限制
+type rateLimitedReader struct {
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+func (rr *rateLimitedReader) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+	if n > 0 {
+		if werr := rr.limiter.WaitN(context.Background(), n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// SetGlobalRateLimit 调整该 Downloader 下所有 Job 共享的全局限速（字节/秒），<= 0 表示不限速
+func (d *Downloader) SetGlobalRateLimit(bytesPerSec int64) {
+	d.Option.MaxGlobalBytesPerSec = bytesPerSec
+	d.limiter.setRate(bytesPerSec)
+}
+
+// SetMaxConnections 调整该 Downloader 下所有 Job 共享的全局并发分片数上限，<= 0 表示不限制
+func (d *Downloader) SetMaxConnections(n int) {
+	d.Option.MaxGlobalConnections = n
+	d.limiter.setMaxConnections(n)
+}
@@ -0,0 +1,305 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mzky/weblink/pkg/base"
+)
+
+// controlFileSuffix 断点续传控制文件的后缀名，与目标文件同目录存放
+const controlFileSuffix = ".wldownload"
+
+// byteRange 表示一段已完成下载的字节区间，闭区间 [Start, End]
+type byteRange struct {
+	Start uint64 `json:"start"`
+	End   uint64 `json:"end"`
+}
+
+// controlFile 是断点续传落盘的控制文件结构，下载开始时由 loadResumeState 读取并据此
+// 判断能否续传；NewJob 创建任务时不读取它
+type controlFile struct {
+	URL          string      `json:"url"`
+	ETag         string      `json:"etag,omitempty"`
+	LastModified string      `json:"lastModified,omitempty"`
+	TotalSize    uint64      `json:"totalSize"`
+	Completed    []byteRange `json:"completed"`
+	CRC64        uint64      `json:"crc64,omitempty"`
+	SHA256       string      `json:"sha256,omitempty"`
+}
+
+func controlFilePath(target string) string {
+	return target + controlFileSuffix
+}
+
+// loadControlFile 读取目标文件旁的 .wldownload，文件不存在时返回 nil
+func loadControlFile(target string) *controlFile {
+	data, err := os.ReadFile(controlFilePath(target))
+	if err != nil {
+		return nil
+	}
+	cf := &controlFile{}
+	if err := json.Unmarshal(data, cf); err != nil {
+		return nil
+	}
+	return cf
+}
+
+func (cf *controlFile) save(target string) error {
+	data, err := json.MarshalIndent(cf, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(controlFilePath(target), data, 0o644)
+}
+
+func removeControlFile(target string) error {
+	if err := os.Remove(controlFilePath(target)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// markCompleted 记录 [start, end] 已下载完成，并与相邻区间合并
+func (cf *controlFile) markCompleted(start, end uint64) {
+	cf.Completed = append(cf.Completed, byteRange{Start: start, End: end})
+
+	sort.Slice(cf.Completed, func(i, j int) bool { return cf.Completed[i].Start < cf.Completed[j].Start })
+
+	merged := cf.Completed[:1]
+	for _, r := range cf.Completed[1:] {
+		last := &merged[len(merged)-1]
+		if r.Start <= last.End+1 {
+			if r.End > last.End {
+				last.End = r.End
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	cf.Completed = merged
+}
+
+// missingRanges 返回尚未下载完成的区间列表，全部完成时返回空切片
+func (cf *controlFile) missingRanges() []byteRange {
+	if cf.TotalSize == 0 {
+		return nil
+	}
+
+	var missing []byteRange
+	cursor := uint64(0)
+	for _, r := range cf.Completed {
+		if r.Start > cursor {
+			missing = append(missing, byteRange{Start: cursor, End: r.Start - 1})
+		}
+		if r.End+1 > cursor {
+			cursor = r.End + 1
+		}
+	}
+	if cursor < cf.TotalSize {
+		missing = append(missing, byteRange{Start: cursor, End: cf.TotalSize - 1})
+	}
+	return missing
+}
+
+// matches 判断控制文件记录的远端资源是否仍与当前任务一致，用于决定能否续传
+func (cf *controlFile) matches(job *Job) bool {
+	if cf.URL != job.Url.String() || cf.TotalSize != job.FileSize {
+		return false
+	}
+	// ETag/Last-Modified 任一发生变化都视为资源已更新，放弃续传
+	if cf.ETag != "" && job.etag != "" && cf.ETag != job.etag {
+		return false
+	}
+	if cf.LastModified != "" && job.lastModified != "" && cf.LastModified != job.lastModified {
+		return false
+	}
+	return true
+}
+
+// Status 返回任务当前所处的生命周期阶段
+func (job *Job) Status() base.Status {
+	job.statusMu.Lock()
+	defer job.statusMu.Unlock()
+	return job.status
+}
+
+func (job *Job) setStatus(s base.Status) {
+	job.statusMu.Lock()
+	job.status = s
+	job.statusMu.Unlock()
+}
+
+// Pause 暂停任务。已完成的分块区间保留在控制文件中，调用 Resume 可从断点继续
+func (job *Job) Pause() {
+	if job.Status() != base.Start {
+		return
+	}
+	job.setStatus(base.Pause)
+	job.stopOnce.Do(func() { close(job.pauseCh) })
+
+	if job.fetcher != nil {
+		job.fetcher.Pause()
+	}
+}
+
+// Resume 从上次暂停处继续下载。只适用于已经调用过 Download 的 Job；进程重启后新建的
+// Job 调用 Resume 会返回错误，此时由 Download 通过控制文件从断点继续
+func (job *Job) Resume() error {
+	if job.Status() != base.Pause && job.Status() != base.Ready {
+		return errors.New("任务未处于可续传状态")
+	}
+	if job.fetcher == nil {
+		return errors.New("任务尚未开始下载，无法续传")
+	}
+
+	job.pauseCh = make(chan struct{})
+	job.stopOnce = sync.Once{}
+	job.setStatus(base.Start)
+
+	if err := job.fetcher.Resume(); err != nil {
+		job.setStatus(base.Error)
+		return err
+	}
+
+	// 续传过程中再次被 Pause 时 fetcher.Resume 会提前返回 nil，任务状态已是
+	// Pause，不应被这里覆盖成 Done
+	if job.Status() != base.Pause {
+		job.setStatus(base.Done)
+	}
+	return nil
+}
+
+// Cancel 取消任务并清除断点续传状态；取消后的 Job 不应再被复用
+func (job *Job) Cancel() {
+	job.setStatus(base.Error)
+	job.stopOnce.Do(func() { close(job.pauseCh) })
+
+	_ = removeControlFile(job.TargetFile())
+
+	if job.fetcher != nil {
+		job.fetcher.Cancel()
+	}
+}
+
+// errPaused 由 copyWithPause 在检测到暂停信号时返回，调用方应据此
+// 更新控制文件而非将其当作下载失败处理。
+var errPaused = errors.New("任务已暂停")
+
+const copyBufSize = 32 * 1024
+
+// copyWithPause 从 src 读取数据写入 w，每次读取前检查 pauseCh 是否已关闭。
+// 用于不具备分块并发、只能靠单个流式拷贝实现暂停的协议（如 FTP）。
+func copyWithPause(w io.Writer, src io.Reader, pauseCh <-chan struct{}) (uint64, error) {
+	buf := make([]byte, copyBufSize)
+	var written uint64
+
+	for {
+		select {
+		case <-pauseCh:
+			return written, errPaused
+		default:
+		}
+
+		n, rerr := src.Read(buf)
+		if n > 0 {
+			wn, werr := w.Write(buf[:n])
+			written += uint64(wn)
+			if werr != nil {
+				return written, werr
+			}
+		}
+		if rerr != nil {
+			if rerr == io.EOF {
+				return written, nil
+			}
+			return written, rerr
+		}
+	}
+}
+
+// loadResumeState 在已知目标路径与远端文件信息后，尝试加载既有控制文件。
+// 找不到控制文件或其记录与远端资源不匹配时，返回一份全新的控制文件。
+func (job *Job) loadResumeState() *controlFile {
+	if cf := loadControlFile(job.TargetFile()); cf != nil && cf.matches(job) {
+		job.logDebug("发现可续传的控制文件，已完成 %d 个区间", len(cf.Completed))
+		return cf
+	}
+
+	return &controlFile{
+		URL:          job.Url.String(),
+		ETag:         job.etag,
+		LastModified: job.lastModified,
+		TotalSize:    job.FileSize,
+	}
+}
+
+// finishDownload 在全部分块下载完成后校验完整性并清理控制文件
+func (job *Job) finishDownload() error {
+	if err := job.verifyIntegrity(); err != nil {
+		return err
+	}
+	return removeControlFile(job.TargetFile())
+}
+
+// verifyIntegrity 按 Option.Integrity 指定的算法计算目标文件摘要；若 Option.ExpectedChecksum
+// 非空，还会与之比对，不一致时返回错误，阻止一个损坏的文件被当作下载成功处理
+func (job *Job) verifyIntegrity() error {
+	switch job.Integrity {
+	case "":
+		return nil
+	case "crc64":
+		h := crc64.New(crc64.MakeTable(crc64.ISO))
+		if err := hashFile(job.TargetFile(), h); err != nil {
+			return err
+		}
+		job.control.CRC64 = h.Sum64()
+		actual := fmt.Sprintf("%x", job.control.CRC64)
+		job.logDebug("CRC64 校验值：%s", actual)
+		return compareChecksum("CRC64", actual, job.ExpectedChecksum)
+	case "sha256":
+		h := sha256.New()
+		if err := hashFile(job.TargetFile(), h); err != nil {
+			return err
+		}
+		job.control.SHA256 = hex.EncodeToString(h.Sum(nil))
+		job.logDebug("SHA256 校验值：%s", job.control.SHA256)
+		return compareChecksum("SHA256", job.control.SHA256, job.ExpectedChecksum)
+	default:
+		return errors.New("不支持的校验算法：" + job.Integrity)
+	}
+}
+
+// compareChecksum 在 expected 非空时比对摘要，大小写不敏感；expected 为空表示调用方
+// 只要求计算摘要而不比对，始终视为通过
+func compareChecksum(algo, actual, expected string) error {
+	if expected == "" {
+		return nil
+	}
+	if !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("完整性校验失败：%s 期望 %s，实际 %s", algo, expected, actual)
+	}
+	return nil
+}
+
+// hashFile 流式读取文件并写入给定的 io.Writer（crc64.Hash、sha256 等 hash.Hash 均适用），
+// 调用方在返回后自行从具体的 hash 实现读取摘要
+func hashFile(path string, h io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(h, f)
+	return err
+}
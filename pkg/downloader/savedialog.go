@@ -0,0 +1,56 @@
+package downloader
+
+import "path/filepath"
+
+// SaveTargetResolver 决定一次下载的最终保存路径。将“是否/如何向用户确认保存位置”
+// 与下载核心逻辑解耦，使得 downloader 包本身不再与任何具体操作系统 API 绑定，
+// 可以在桌面端弹出系统保存对话框，也可以在服务器/CI 等无图形界面场景下直接落盘。
+type SaveTargetResolver interface {
+	// Resolve 传入按 Job.Dir/FileName 约定计算出的默认目标路径，返回用户确认后的
+	// 最终保存路径；ok 为 false 表示用户取消了保存。
+	Resolve(defaultTarget string) (target string, ok bool)
+}
+
+// NoopResolver 不弹出任何对话框，直接使用预先计算好的目标路径，
+// 适用于服务器、CI 等没有图形界面的场景。
+type NoopResolver struct{}
+
+func (NoopResolver) Resolve(defaultTarget string) (string, bool) {
+	return defaultTarget, true
+}
+
+// SetSaveTargetResolver 替换该 Downloader 下所有 Job 弹出保存对话框的方式。
+// 未调用时使用当前平台的默认实现（Windows 下为系统保存对话框）。
+func (d *Downloader) SetSaveTargetResolver(resolver SaveTargetResolver) {
+	d.resolverMu.Lock()
+	defer d.resolverMu.Unlock()
+	d.saveTargetResolver = resolver
+}
+
+// resolveTarget 计算本次下载的最终保存路径。EnableSaveFileDialog 为 false 时
+// 完全不会触碰任何 SaveTargetResolver（也就不会触碰任何 OS 相关代码），直接使用
+// 已经确定好的 TargetFile；为 true 时才会委托给 Downloader.saveTargetResolver。
+func (job *Job) resolveTarget() (string, bool) {
+	if !job.EnableSaveFileDialog {
+		return job.TargetFile(), true
+	}
+
+	job.downloader.resolverMu.RLock()
+	resolver := job.downloader.saveTargetResolver
+	job.downloader.resolverMu.RUnlock()
+
+	if resolver == nil {
+		resolver = NoopResolver{}
+	}
+
+	path, ok := resolver.Resolve(job.TargetFile())
+	if !ok {
+		return "", false
+	}
+
+	dir, file := filepath.Split(path)
+	job.FileName = file
+	job.Dir = dir
+
+	return path, true
+}
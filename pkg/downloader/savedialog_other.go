@@ -0,0 +1,20 @@
+//go:build !windows
+
+package downloader
+
+import "github.com/sqweek/dialog"
+
+// unixResolver 在非 Windows 平台上通过 GTK/AppKit 原生保存对话框确认保存路径。
+type unixResolver struct{}
+
+func defaultSaveTargetResolver() SaveTargetResolver {
+	return unixResolver{}
+}
+
+func (unixResolver) Resolve(defaultTarget string) (filePath string, ok bool) {
+	filePath, err := dialog.File().Title("保存文件").SetStartFile(defaultTarget).Save()
+	if err != nil {
+		return "", false
+	}
+	return filePath, true
+}
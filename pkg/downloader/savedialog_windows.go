@@ -0,0 +1,34 @@
+//go:build windows
+
+package downloader
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/lxn/win"
+)
+
+// windowsResolver 通过系统的“另存为”对话框确认保存路径，迁移自原先内联在
+// downloadHttp/downloadFtp 中的 openSaveFileDialog。
+type windowsResolver struct{}
+
+func defaultSaveTargetResolver() SaveTargetResolver {
+	return windowsResolver{}
+}
+
+func (windowsResolver) Resolve(defaultTarget string) (filePath string, ok bool) {
+	var ofn win.OPENFILENAME
+	buf := make([]uint16, syscall.MAX_PATH)
+	ofn.LStructSize = uint32(unsafe.Sizeof(ofn))
+	ofn.NMaxFile = uint32(len(buf))
+	ofn.LpstrFile, _ = syscall.UTF16PtrFromString(defaultTarget)
+	ofn.Flags = win.OFN_OVERWRITEPROMPT | win.OFN_EXPLORER | win.OFN_FILEMUSTEXIST | win.OFN_PATHMUSTEXIST | win.OFN_LONGNAMES
+	ofn.LpstrTitle, _ = syscall.UTF16PtrFromString("保存文件")
+	ofn.LpstrFilter, _ = syscall.UTF16PtrFromString("All Files (*.*)")
+	ok = win.GetSaveFileName(&ofn)
+	if ok {
+		filePath = syscall.UTF16ToString((*[1 << 16]uint16)(unsafe.Pointer(ofn.LpstrFile))[:])
+	}
+	return
+}
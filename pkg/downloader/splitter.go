@@ -0,0 +1,58 @@
+package downloader
+
+// Chunk 描述一个可独立下载的分片：字节区间 [Start, End]。下载时直接写入目标文件中
+// 该偏移处（见 downloadChunkToFile），不再经由独立的 part 文件中转，因此暂停时
+// 已下载的分片不会因为缺少后续的合并步骤而丢失。
+type Chunk struct {
+	Index int
+	Start uint64
+	End   uint64
+}
+
+// Splitter 把一组待下载的字节区间（通常来自 controlFile.missingRanges）切分成
+// 一组固定大小（MinChunkSize）的 Chunk。
+type Splitter struct {
+	minChunkSize uint64
+}
+
+func newSplitter(minChunkSize uint64) *Splitter {
+	return &Splitter{minChunkSize: minChunkSize}
+}
+
+// Split 依次切分每个区间，保证分片大小严格为 MinChunkSize（最后一片为余数），
+// 而不是像旧实现那样用总大小除以线程数得出分片大小。每个区间独立起算分片大小，
+// 避免一个区间（如续传时较小的缺口）影响其后区间的切分粒度。
+func (s *Splitter) Split(ranges []byteRange) []Chunk {
+	var chunks []Chunk
+	for _, r := range ranges {
+		size := s.minChunkSize
+		total := r.End - r.Start + 1
+		if size == 0 || size > total {
+			size = total
+		}
+
+		for start := r.Start; start <= r.End; start += size {
+			end := start + size - 1
+			if end > r.End {
+				end = r.End
+			}
+
+			chunks = append(chunks, Chunk{
+				Index: len(chunks),
+				Start: start,
+				End:   end,
+			})
+		}
+	}
+
+	return chunks
+}
+
+// chunkRanges 提取 Chunk 列表对应的字节区间，供进度上报复用
+func chunkRanges(chunks []Chunk) []byteRange {
+	ranges := make([]byteRange, len(chunks))
+	for i, c := range chunks {
+		ranges[i] = byteRange{Start: c.Start, End: c.End}
+	}
+	return ranges
+}